@@ -0,0 +1,439 @@
+// Copyright 2021 The SucuriAPI AUTHORS. All rights reserved.
+//
+// Use of this source code is governed by an MIT License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ZsoltFejes/SucuriAPI-Go"
+)
+
+// daemonMaxExpand bounds how many addresses a template subnet is expanded to
+// while reconciling in daemon mode, so the applied-state diff stays over
+// individual IPs regardless of the --expandSubnets/--maxExpand flags used for
+// one-shot runs.
+const daemonMaxExpand = 4096
+
+// daemonAuth holds the credentials the control API checks incoming requests
+// against. Either BearerToken or the BasicAuthUser/BasicAuthPass pair may be
+// set; a request is rejected unless one of them matches.
+type daemonAuth struct {
+	BearerToken   string `json:"bearerToken,omitempty"`
+	BasicAuthUser string `json:"basicAuthUser,omitempty"`
+	BasicAuthPass string `json:"basicAuthPass,omitempty"`
+}
+
+func (a daemonAuth) configured() bool {
+	return len(a.BearerToken) > 0 || (len(a.BasicAuthUser) > 0 && len(a.BasicAuthPass) > 0)
+}
+
+func (a daemonAuth) allows(r *http.Request) bool {
+	if !a.configured() {
+		return false
+	}
+	if len(a.BearerToken) > 0 {
+		if header := r.Header.Get("Authorization"); header == "Bearer "+a.BearerToken {
+			return true
+		}
+	}
+	if len(a.BasicAuthUser) > 0 {
+		user, pass, ok := r.BasicAuth()
+		if ok && user == a.BasicAuthUser && pass == a.BasicAuthPass {
+			return true
+		}
+	}
+	return false
+}
+
+// appliedState is the daemon's cached snapshot of what has already been
+// pushed to Sucuri, so reload only has to submit the entries that changed.
+type appliedState struct {
+	AllowIPs   map[string]bool
+	DenyIPs    map[string]bool
+	AllowPaths map[string]string
+	DenyPaths  map[string]string
+	Settings   map[string]string
+}
+
+func newAppliedState() *appliedState {
+	return &appliedState{
+		AllowIPs:   make(map[string]bool),
+		DenyIPs:    make(map[string]bool),
+		AllowPaths: make(map[string]string),
+		DenyPaths:  make(map[string]string),
+		Settings:   make(map[string]string),
+	}
+}
+
+// daemon is the long running process started by --serve. It exposes an HTTP
+// control API, watches the template file for changes, and reconciles the
+// template's desired state against Sucuri by diffing it against a cached
+// snapshot of what was already applied.
+type daemon struct {
+	mu           sync.Mutex
+	sucuri       *SucuriAPI.Sucuri
+	templatePath string
+	auth         daemonAuth
+	applied      *appliedState
+}
+
+func newDaemon(sucuri *SucuriAPI.Sucuri, templatePath string, auth daemonAuth) *daemon {
+	return &daemon{
+		sucuri:       sucuri,
+		templatePath: templatePath,
+		auth:         auth,
+		applied:      newAppliedState(),
+	}
+}
+
+// serve starts the HTTP control API and blocks until it exits.
+func (d *daemon) serve(addr string, reloadInterval time.Duration) error {
+	if len(d.templatePath) > 0 {
+		if err := d.reload(); err != nil {
+			log.Printf("initial template load failed: %s", err)
+		}
+		go d.watchTemplate()
+	}
+	if reloadInterval > 0 {
+		go d.pollReload(reloadInterval)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/allowlist/ip", d.authenticated(d.handleListIP(true)))
+	mux.HandleFunc("/denylist/ip", d.authenticated(d.handleListIP(false)))
+	mux.HandleFunc("/paths", d.authenticated(d.handlePaths))
+	mux.HandleFunc("/settings", d.authenticated(d.handleSettings))
+	mux.HandleFunc("/reload", d.authenticated(d.handleReload))
+
+	log.Printf("daemon listening on %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// authenticated wraps a handler so it only runs once the request has been
+// checked against the configured basic auth credentials or bearer token.
+func (d *daemon) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.auth.allows(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="SucuriAPI"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// watchTemplate reloads the template whenever it changes on disk.
+func (d *daemon) watchTemplate() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("unable to watch template file: %s", err)
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(d.templatePath); err != nil {
+		log.Printf("unable to watch template file: %s", err)
+		return
+	}
+
+	for event := range watcher.Events {
+		if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+			continue
+		}
+		if err := d.reload(); err != nil {
+			log.Printf("reload after template change failed: %s", err)
+		}
+	}
+}
+
+// pollReload re-reads the template on a fixed interval, as a fallback for
+// remote list sources that fsnotify can't watch.
+func (d *daemon) pollReload(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := d.reload(); err != nil {
+			log.Printf("scheduled reload failed: %s", err)
+		}
+	}
+}
+
+// reload re-reads the template file, computes the desired allow/deny state
+// and reconciles it against the cached snapshot of what's already applied,
+// only issuing SucuriRequests for the adds and removes.
+func (d *daemon) reload() error {
+	if len(d.templatePath) == 0 {
+		return nil
+	}
+
+	file, err := ioutil.ReadFile(d.templatePath)
+	if err != nil {
+		return fmt.Errorf("reading template: %w", err)
+	}
+	template := Template{Settings: make(map[string]string), AllowlistPath: make(map[string]string), DenylistPath: make(map[string]string)}
+	if err := json.Unmarshal(file, &template); err != nil {
+		return fmt.Errorf("parsing template: %w", err)
+	}
+	template.resolveLegacyAliases()
+
+	allowIPs := append([]string{}, template.AllowlistIP...)
+	denyIPs := append([]string{}, template.DenylistIP...)
+	allowSubnets := append([]string{}, template.AllowlistSubnet...)
+	denySubnets := append([]string{}, template.DenylistSubnet...)
+	if len(template.Sources) > 0 {
+		if err := applySources(template.Sources, sourceCacheDir(), &allowIPs, &denyIPs, &allowSubnets, &denySubnets, template.AllowlistPath, template.DenylistPath); err != nil {
+			return fmt.Errorf("fetching sources: %w", err)
+		}
+	}
+
+	for _, subnet := range allowSubnets {
+		ips, err := getUsableIPs(subnet, daemonMaxExpand)
+		if err != nil {
+			return fmt.Errorf("expanding allowlist subnet %s: %w", subnet, err)
+		}
+		allowIPs = append(allowIPs, ips...)
+	}
+	for _, subnet := range denySubnets {
+		ips, err := getUsableIPs(subnet, daemonMaxExpand)
+		if err != nil {
+			return fmt.Errorf("expanding denylist subnet %s: %w", subnet, err)
+		}
+		denyIPs = append(denyIPs, ips...)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var requests []LabeledRequest
+	requests = append(requests, d.diffIPs(allowIPs, d.applied.AllowIPs, true)...)
+	requests = append(requests, d.diffIPs(denyIPs, d.applied.DenyIPs, false)...)
+	requests = append(requests, d.diffPaths(template.AllowlistPath, d.applied.AllowPaths, true)...)
+	requests = append(requests, d.diffPaths(template.DenylistPath, d.applied.DenyPaths, false)...)
+	requests = append(requests, d.diffSettings(template.Settings, d.applied.Settings)...)
+
+	var wg sync.WaitGroup
+	for _, request := range requests {
+		wg.Add(1)
+		go submitRequest(request, &wg)
+	}
+	wg.Wait()
+
+	log.Printf("reload applied %d change(s)", len(requests))
+	return nil
+}
+
+// diffIPs compares the desired IP list against the applied snapshot and
+// returns requests for the entries that were added or removed, updating the
+// snapshot in place.
+func (d *daemon) diffIPs(desired []string, applied map[string]bool, allow bool) []LabeledRequest {
+	desiredSet := make(map[string]bool, len(desired))
+	var requests []LabeledRequest
+	for _, ip := range desired {
+		desiredSet[ip] = true
+		if applied[ip] {
+			continue
+		}
+		if allow {
+			requests = append(requests, allowlistIPRequest(d.sucuri, ip, false))
+		} else {
+			requests = append(requests, denylistIPRequest(d.sucuri, ip, false))
+		}
+		applied[ip] = true
+	}
+	for ip := range applied {
+		if desiredSet[ip] {
+			continue
+		}
+		if allow {
+			requests = append(requests, allowlistIPRequest(d.sucuri, ip, true))
+		} else {
+			requests = append(requests, denylistIPRequest(d.sucuri, ip, true))
+		}
+		delete(applied, ip)
+	}
+	return requests
+}
+
+// diffPaths is the path-map counterpart of diffIPs.
+func (d *daemon) diffPaths(desired map[string]string, applied map[string]string, allow bool) []LabeledRequest {
+	var requests []LabeledRequest
+	for path, pattern := range desired {
+		if applied[path] == pattern {
+			continue
+		}
+		if allow {
+			requests = append(requests, allowlistPathRequest(d.sucuri, path, pattern))
+		} else {
+			requests = append(requests, denylistPathRequest(d.sucuri, path, pattern))
+		}
+		applied[path] = pattern
+	}
+	for path, pattern := range applied {
+		if _, ok := desired[path]; ok {
+			continue
+		}
+		if allow {
+			requests = append(requests, allowlistPathRequest(d.sucuri, path, pattern))
+		} else {
+			requests = append(requests, denylistPathRequest(d.sucuri, path, pattern))
+		}
+		delete(applied, path)
+	}
+	return requests
+}
+
+// diffSettings compares the desired settings against the applied snapshot and
+// returns update requests only for the ones that actually changed, updating
+// the snapshot in place. Settings are never removed, matching the one-shot
+// flow's "Settings can't be removed" behavior.
+func (d *daemon) diffSettings(desired map[string]string, applied map[string]string) []LabeledRequest {
+	var requests []LabeledRequest
+	for key, value := range desired {
+		if applied[key] == value {
+			continue
+		}
+		requests = append(requests, updateSettingRequest(d.sucuri, key, value))
+		applied[key] = value
+	}
+	return requests
+}
+
+type ipRequestBody struct {
+	IP     string `json:"ip"`
+	Delete bool   `json:"delete"`
+}
+
+// handleListIP handles POST /allowlist/ip and POST /denylist/ip: it submits
+// a single IP add/remove immediately and keeps the applied snapshot in sync
+// so the next reload doesn't treat it as a stray entry to clean up.
+func (d *daemon) handleListIP(allow bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body ipRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.IP) == 0 {
+			http.Error(w, "expected a JSON body with an \"ip\" field", http.StatusBadRequest)
+			return
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var request LabeledRequest
+		set := d.applied.DenyIPs
+		if allow {
+			set = d.applied.AllowIPs
+			request = allowlistIPRequest(d.sucuri, body.IP, body.Delete)
+		} else {
+			request = denylistIPRequest(d.sucuri, body.IP, body.Delete)
+		}
+		if _, err := request.submit(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if body.Delete {
+			delete(set, body.IP)
+		} else {
+			set[body.IP] = true
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+type pathRequestBody struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	List    string `json:"list"`
+	Delete  bool   `json:"delete"`
+}
+
+// handlePaths handles POST /paths: it allow/denylists a single URL path,
+// selected by the body's "list" field ("allow" or "deny").
+func (d *daemon) handlePaths(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body pathRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Path) == 0 || len(body.Pattern) == 0 {
+		http.Error(w, "expected a JSON body with \"path\" and \"pattern\" fields", http.StatusBadRequest)
+		return
+	}
+	allow := strings.EqualFold(body.List, "allow")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var request LabeledRequest
+	set := d.applied.DenyPaths
+	if allow {
+		set = d.applied.AllowPaths
+		request = allowlistPathRequest(d.sucuri, body.Path, body.Pattern)
+	} else {
+		request = denylistPathRequest(d.sucuri, body.Path, body.Pattern)
+	}
+	if _, err := request.submit(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if body.Delete {
+		delete(set, body.Path)
+	} else {
+		set[body.Path] = body.Pattern
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type settingRequestBody struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// handleSettings handles PATCH /settings: it changes a single Sucuri
+// setting, e.g. {"key": "securitylevel", "value": "paranoid"}.
+func (d *daemon) handleSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body settingRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Key) == 0 || len(body.Value) == 0 {
+		http.Error(w, "expected a JSON body with \"key\" and \"value\" fields", http.StatusBadRequest)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := updateSettingRequest(d.sucuri, body.Key, body.Value).submit(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.applied.Settings[body.Key] = body.Value
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleReload handles POST /reload: it re-reads the template file and
+// reconciles Sucuri against it immediately, without waiting for fsnotify or
+// the poll interval.
+func (d *daemon) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := d.reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
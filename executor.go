@@ -0,0 +1,168 @@
+// Copyright 2021 The SucuriAPI AUTHORS. All rights reserved.
+//
+// Use of this source code is governed by an MIT License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of submitting a single LabeledRequest through an
+// Executor.
+type Result struct {
+	Label    string
+	Response string
+	Err      error
+	Attempts int
+}
+
+// Executor submits LabeledRequests with a bounded worker pool and a
+// token-bucket rate limit, retrying retryable failures (429s, timeouts, dropped
+// connections) with exponential backoff and jitter. This keeps bulk imports of
+// large allow/deny lists from hammering the Sucuri API or silently dropping
+// failures the way an unbounded goroutine-per-request loop does.
+type Executor struct {
+	Concurrency int
+	RPS         float64
+	MaxRetries  int
+	Timeout     time.Duration
+}
+
+// NewExecutor returns an Executor with sane defaults; concurrency is floored
+// at 1 and a non-positive rps disables rate limiting.
+func NewExecutor(concurrency int, rps float64) *Executor {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Executor{
+		Concurrency: concurrency,
+		RPS:         rps,
+		MaxRetries:  3,
+		Timeout:     30 * time.Second,
+	}
+}
+
+// Run submits every request, bounded by Concurrency and RPS, and returns one
+// Result per request in the same order the requests were given.
+func (e *Executor) Run(ctx context.Context, requests []LabeledRequest) []Result {
+	results := make([]Result, len(requests))
+
+	var limiter *rateLimiter
+	if e.RPS > 0 {
+		limiter = newRateLimiter(e.RPS)
+	}
+
+	sem := make(chan struct{}, e.Concurrency)
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, request LabeledRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if limiter != nil {
+				limiter.wait(ctx)
+			}
+			results[i] = e.submitWithRetry(ctx, request)
+		}(i, request)
+	}
+	wg.Wait()
+	return results
+}
+
+// submitWithRetry submits a single request, retrying with exponential
+// backoff and jitter while it keeps failing with a retryable error and the
+// retry budget and context allow it.
+func (e *Executor) submitWithRetry(ctx context.Context, request LabeledRequest) Result {
+	var lastErr error
+	for attempt := 1; attempt <= e.MaxRetries+1; attempt++ {
+		reqCtx, cancel := context.WithTimeout(ctx, e.Timeout)
+		response, err := request.submit(reqCtx)
+		cancel()
+
+		if err == nil {
+			return Result{Label: request.Label, Response: response, Attempts: attempt}
+		}
+		lastErr = err
+		if !isRetryable(err) || attempt > e.MaxRetries {
+			break
+		}
+
+		backoff := time.Duration(math.Pow(2, float64(attempt-1))) * 500 * time.Millisecond
+		backoff += time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return Result{Label: request.Label, Err: ctx.Err(), Attempts: attempt}
+		}
+	}
+	return Result{Label: request.Label, Err: lastErr, Attempts: e.MaxRetries + 1}
+}
+
+// isRetryable reports whether err looks like a transient failure worth
+// retrying, such as a 429 or a network timeout, rather than a permanent
+// rejection like a bad API key.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, marker := range []string{"429", "too many requests", "timeout", "temporary", "connection reset", "eof"} {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter is a minimal token-bucket limiter ticking at rps tokens per
+// second, with a bucket size of one - just enough to smooth out bursts from
+// the worker pool without needing an external dependency.
+type rateLimiter struct {
+	tokens chan struct{}
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	l := &rateLimiter{tokens: make(chan struct{}, 1)}
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rps))
+		defer ticker.Stop()
+		for range ticker.C {
+			select {
+			case l.tokens <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return l
+}
+
+func (l *rateLimiter) wait(ctx context.Context) {
+	select {
+	case <-l.tokens:
+	case <-ctx.Done():
+	}
+}
+
+// PrintSummary prints a one-line-per-request summary table and reports
+// whether every request succeeded.
+func PrintSummary(results []Result) bool {
+	allOK := true
+	for _, result := range results {
+		status := "ok"
+		if result.Err != nil {
+			status = "failed: " + result.Err.Error()
+			allOK = false
+		}
+		fmt.Printf("%-40s attempts=%d %s\n", result.Label, result.Attempts, status)
+	}
+	return allOK
+}
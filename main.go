@@ -6,104 +6,247 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ZsoltFejes/SucuriAPI-Go"
 )
 
 type Template struct {
-	WhitelistIP     []string          `json:"whitelistIPs,omitempty"`
-	BlacklistIP     []string          `json:"blacklistIPs,omitempty"`
-	WhitelistSubnet []string          `json:"whitelistSubnets,omitempty"`
-	BlacklistSubnet []string          `json:"blacklistSubnets,omitempty"`
-	WhitelistPath   map[string]string `json:"whitelistPaths,omitempty"`
-	BlacklistPath   map[string]string `json:"blacklistPaths,omitempty"`
+	AllowlistIP     []string          `json:"allowlistIPs,omitempty"`
+	DenylistIP      []string          `json:"denylistIPs,omitempty"`
+	AllowlistSubnet []string          `json:"allowlistSubnets,omitempty"`
+	DenylistSubnet  []string          `json:"denylistSubnets,omitempty"`
+	AllowlistPath   map[string]string `json:"allowlistPaths,omitempty"`
+	DenylistPath    map[string]string `json:"denylistPaths,omitempty"`
 	Settings        map[string]string `json:"settings,omitempty"`
+	// Groups holds named rule sets, selected at runtime with --group, so a
+	// single template can carry rules for several client groups (e.g. "office",
+	// "kids") and apply only one of them per invocation.
+	Groups map[string]Template `json:"groups,omitempty"`
+	// Sources lists remote allow/deny lists to fetch and merge in, in
+	// addition to this template's own fields.
+	Sources []Source `json:"sources,omitempty"`
+
+	// Deprecated: use AllowlistIP instead.
+	WhitelistIP []string `json:"whitelistIPs,omitempty"`
+	// Deprecated: use DenylistIP instead.
+	BlacklistIP []string `json:"blacklistIPs,omitempty"`
+	// Deprecated: use AllowlistSubnet instead.
+	WhitelistSubnet []string `json:"whitelistSubnets,omitempty"`
+	// Deprecated: use DenylistSubnet instead.
+	BlacklistSubnet []string `json:"blacklistSubnets,omitempty"`
+	// Deprecated: use AllowlistPath instead.
+	WhitelistPath map[string]string `json:"whitelistPaths,omitempty"`
+	// Deprecated: use DenylistPath instead.
+	BlacklistPath map[string]string `json:"blacklistPaths,omitempty"`
+}
+
+// resolveLegacyAliases folds the deprecated whitelist*/blacklist* fields into
+// their allowlist*/denylist* replacements and logs a warning for each one
+// that was used, so existing templates keep working after the rename.
+func (t *Template) resolveLegacyAliases() {
+	if len(t.WhitelistIP) > 0 {
+		log.Println(`warning: template field "whitelistIPs" is deprecated, use "allowlistIPs" instead`)
+		t.AllowlistIP = append(t.AllowlistIP, t.WhitelistIP...)
+	}
+	if len(t.BlacklistIP) > 0 {
+		log.Println(`warning: template field "blacklistIPs" is deprecated, use "denylistIPs" instead`)
+		t.DenylistIP = append(t.DenylistIP, t.BlacklistIP...)
+	}
+	if len(t.WhitelistSubnet) > 0 {
+		log.Println(`warning: template field "whitelistSubnets" is deprecated, use "allowlistSubnets" instead`)
+		t.AllowlistSubnet = append(t.AllowlistSubnet, t.WhitelistSubnet...)
+	}
+	if len(t.BlacklistSubnet) > 0 {
+		log.Println(`warning: template field "blacklistSubnets" is deprecated, use "denylistSubnets" instead`)
+		t.DenylistSubnet = append(t.DenylistSubnet, t.BlacklistSubnet...)
+	}
+	if len(t.WhitelistPath) > 0 {
+		log.Println(`warning: template field "whitelistPaths" is deprecated, use "allowlistPaths" instead`)
+		if t.AllowlistPath == nil {
+			t.AllowlistPath = make(map[string]string)
+		}
+		for path, pattern := range t.WhitelistPath {
+			t.AllowlistPath[path] = pattern
+		}
+	}
+	if len(t.BlacklistPath) > 0 {
+		log.Println(`warning: template field "blacklistPaths" is deprecated, use "denylistPaths" instead`)
+		if t.DenylistPath == nil {
+			t.DenylistPath = make(map[string]string)
+		}
+		for path, pattern := range t.BlacklistPath {
+			t.DenylistPath[path] = pattern
+		}
+	}
 }
 
 type ConfigFile struct {
 	ApiKey string            `json:"apiKey,omitempty"`
 	Sites  map[string]string `json:"sites,omitempty"`
+	// Daemon holds the control API credentials used when running with
+	// --serve.
+	Daemon daemonAuth `json:"daemon,omitempty"`
 }
 
 // Submit request and notify the wait group after the request has been completed
-func submitRequest(request SucuriAPI.SucuriRequest, wg *sync.WaitGroup) {
-	request.Submit()
-	wg.Done()
+func submitRequest(request LabeledRequest, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if _, err := request.submit(context.Background()); err != nil {
+		log.Printf("%s: %s", request.Label, err)
+	}
 }
 
-// Whitelist a list of IP addresses, if delete is true it will remove the listed IP addresses from the whitelisted IPs
-func whitelistIPs(IPs []string, delete bool, sucuri *SucuriAPI.Sucuri) []SucuriAPI.SucuriRequest {
-	var requests []SucuriAPI.SucuriRequest
+// Allowlist a list of IP addresses, if delete is true it will remove the listed IP addresses from the allowlisted IPs
+func allowlistIPs(IPs []string, delete bool, sucuri *SucuriAPI.Sucuri) []LabeledRequest {
+	var requests []LabeledRequest
 	for _, ip := range IPs {
-		requests = append(requests, sucuri.WhitelistIP(ip, delete))
+		requests = append(requests, allowlistIPRequest(sucuri, ip, delete))
 	}
 	return requests
 }
 
-// Blacklist a list of IP addresses, if delete is true it will remove the listed IP addresses from the whitelisted IPs
-func blacklistIPs(IPs []string, delete bool, sucuri *SucuriAPI.Sucuri) []SucuriAPI.SucuriRequest {
-	var requests []SucuriAPI.SucuriRequest
+// Denylist a list of IP addresses, if delete is true it will remove the listed IP addresses from the denylisted IPs
+func denylistIPs(IPs []string, delete bool, sucuri *SucuriAPI.Sucuri) []LabeledRequest {
+	var requests []LabeledRequest
 	for _, ip := range IPs {
-		requests = append(requests, sucuri.BlacklistIP(ip, delete))
+		requests = append(requests, denylistIPRequest(sucuri, ip, delete))
 	}
 	return requests
 }
 
-func getUsableIPs(subnet string) []string {
-	// convert string to IPNet struct
-	_, ipv4Net, err := net.ParseCIDR(subnet)
-	if err != nil {
-		log.Fatalln(err)
+// getUsableIPs expands a CIDR subnet into its individual addresses. It
+// supports both IPv4 and IPv6 via netip.Prefix iteration, and refuses to
+// expand past maxExpand addresses so a short prefix like /8 can't exhaust
+// memory - Sucuri's API has no CIDR submission endpoint, so every subnet
+// allowlisted/denylisted via allowlistSubnets/denylistSubnets is expanded
+// through this function.
+func getUsableIPs(subnet string, maxExpand int) ([]string, error) {
+	if maxExpand <= 0 {
+		return nil, fmt.Errorf("--maxExpand must be greater than 0, got %d", maxExpand)
 	}
 
-	// convert IPNet struct mask and address to uint32
-	// network is BigEndian
-	mask := binary.BigEndian.Uint32(ipv4Net.Mask)
-	start := binary.BigEndian.Uint32(ipv4Net.IP)
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return nil, err
+	}
+	prefix = prefix.Masked()
 
-	// find the final address
-	finish := start | (mask ^ 0xffffffff)
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 63 {
+		return nil, fmt.Errorf("subnet %s is too large to expand, use --maxExpand or submit it as a CIDR instead", subnet)
+	}
+	if count := uint64(1) << uint(hostBits); count > uint64(maxExpand) {
+		return nil, fmt.Errorf("subnet %s expands to %d addresses, which exceeds --maxExpand (%d)", subnet, count, maxExpand)
+	}
 
 	var ips []string
-	// loop through addresses as uint32
-	for i := start; i <= finish; i++ {
-		// convert back to net.IP
-		ip := make(net.IP, 4)
-		binary.BigEndian.PutUint32(ip, i)
-		ips = append(ips, ip.String())
-	}
-	ips = ips[1 : len(ips)-1]
-	return ips
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		ips = append(ips, addr.String())
+	}
+
+	// Skip the network and broadcast addresses, but only for IPv4 prefixes
+	// shorter than /31 - IPv6 has no broadcast address, and /31 and /32 are
+	// both fully usable point-to-point ranges.
+	if prefix.Addr().Is4() && prefix.Bits() < 31 && len(ips) > 2 {
+		ips = ips[1 : len(ips)-1]
+	}
+	return ips, nil
+}
+
+// allowlistSubnets builds requests for a list of CIDR subnets by expanding
+// each one into individual addresses via getUsableIPs - Sucuri's API has no
+// CIDR submission endpoint, so there's no other way to allowlist a subnet.
+func allowlistSubnets(subnets []string, delete bool, maxExpand int, sucuri *SucuriAPI.Sucuri) ([]LabeledRequest, error) {
+	var requests []LabeledRequest
+	for _, subnet := range subnets {
+		ips, err := getUsableIPs(subnet, maxExpand)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, allowlistIPs(ips, delete, sucuri)...)
+	}
+	return requests, nil
+}
+
+// denylistSubnets is the denylist counterpart of allowlistSubnets.
+func denylistSubnets(subnets []string, delete bool, maxExpand int, sucuri *SucuriAPI.Sucuri) ([]LabeledRequest, error) {
+	var requests []LabeledRequest
+	for _, subnet := range subnets {
+		ips, err := getUsableIPs(subnet, maxExpand)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, denylistIPs(ips, delete, sucuri)...)
+	}
+	return requests, nil
 }
 
 func main() {
+	// "plan" is shorthand for --dryRun, e.g. `SucuriAPI plan --template t.json`.
+	dryRunSubcommand := false
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		dryRunSubcommand = true
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	apiKey := flag.String("key", "", "Sucuri API Key for the site")
 	apiSecret := flag.String("secret", "", "Sucuri API Secret for the site")
-	whitelistIP := flag.String("whitelistIP", "", "Whitelist IP, or multiple IPs, example 200.0.0.1 or 200.0.0.1,200.0.0.10,200.0.0.175")
-	blacklistIP := flag.String("blacklistIP", "", "Blacklist IP, or multiple IPs, example 200.0.0.1 or 200.0.0.1,200.0.0.10,200.0.0.175")
-	whitelistSubnet := flag.String("whitelistSubnet", "", "Whitelist Subnet(s), example 200.0.0.0/27 or 200.0.0.0/27,200.0.1.0/30")
-	blacklistSubnet := flag.String("blacklistSubnet", "", "Whitelist Subnet(s), example 200.0.0.0/27 or 200.0.0.0/27,200.0.1.0/30")
-	whitelistPath := flag.String("whitelistPath", "", "Whitelist URL Path, ('/home/contacts.html')")
-	blacklistPath := flag.String("blacklistPath", "", "Blacklist URL Path, ('/home/contacts.html')")
-	pathPattern := flag.String("pathPattern", "", "Path Pattern, can only be used with whitelistPath and blacklistPath (matches|begins_with|ends_with|equals)")
-	delete := flag.Bool("delete", false, "Use flag to remove entries, (Settings can't be removed only whitelisted/blacklisted entries)")
+	allowlistIP := flag.String("allowlistIP", "", "Allowlist IP, or multiple IPs, example 200.0.0.1 or 200.0.0.1,200.0.0.10,200.0.0.175")
+	denylistIP := flag.String("denylistIP", "", "Denylist IP, or multiple IPs, example 200.0.0.1 or 200.0.0.1,200.0.0.10,200.0.0.175")
+	allowlistSubnet := flag.String("allowlistSubnet", "", "Allowlist Subnet(s), example 200.0.0.0/27 or 200.0.0.0/27,200.0.1.0/30")
+	denylistSubnet := flag.String("denylistSubnet", "", "Denylist Subnet(s), example 200.0.0.0/27 or 200.0.0.0/27,200.0.1.0/30")
+	allowlistPath := flag.String("allowlistPath", "", "Allowlist URL Path, ('/home/contacts.html')")
+	denylistPath := flag.String("denylistPath", "", "Denylist URL Path, ('/home/contacts.html')")
+	// Deprecated: use --allowlistIP instead.
+	whitelistIP := flag.String("whitelistIP", "", "Deprecated, use --allowlistIP instead")
+	// Deprecated: use --denylistIP instead.
+	blacklistIP := flag.String("blacklistIP", "", "Deprecated, use --denylistIP instead")
+	// Deprecated: use --allowlistSubnet instead.
+	whitelistSubnet := flag.String("whitelistSubnet", "", "Deprecated, use --allowlistSubnet instead")
+	// Deprecated: use --denylistSubnet instead.
+	blacklistSubnet := flag.String("blacklistSubnet", "", "Deprecated, use --denylistSubnet instead")
+	// Deprecated: use --allowlistPath instead.
+	whitelistPath := flag.String("whitelistPath", "", "Deprecated, use --allowlistPath instead")
+	// Deprecated: use --denylistPath instead.
+	blacklistPath := flag.String("blacklistPath", "", "Deprecated, use --denylistPath instead")
+	pathPattern := flag.String("pathPattern", "", "Path Pattern, can only be used with allowlistPath and denylistPath (matches|begins_with|ends_with|equals)")
+	delete := flag.Bool("delete", false, "Use flag to remove entries, (Settings can't be removed only allowlisted/denylisted entries)")
 	showSettingOptions := flag.Bool("settingOptions", false, "Show Setting options")
 	setting := flag.String("setting", "", "Change a setting, you can find setting names by running SucuriAPI --settingOptions")
 	settingVal := flag.String("settingVal", "", "Setting Value used with --setting. To see possible values for a setting run SucuriAPI --settingOptions and see the values listed between parentheses")
-	templatePath := flag.String("template", "", "Set path to tempalte and apply all specified settings, whitelists and blacklists")
+	templatePath := flag.String("template", "", "Set path to tempalte and apply all specified settings, allowlists and denylists")
+	group := flag.String("group", "", "Name of the rule group to apply from the template's \"groups\" block, requires --template")
+	expandSubnets := flag.Bool("expandSubnets", true, "Kept for backwards compatibility; subnets are always expanded into individual IP addresses since Sucuri's API has no CIDR submission endpoint, so this can no longer be set to false")
+	maxExpand := flag.Int("maxExpand", 65536, "Maximum number of addresses a subnet may expand to")
 	site := flag.String("site", "", "If you store the apiKey and sites in api.json file specify which site you want to apply changes")
+	serveAddr := flag.String("serve", "", "Run as a daemon exposing an HTTP control API on this address (e.g. :8080) instead of applying once and exiting, requires --template")
+	reloadInterval := flag.Duration("reloadInterval", 5*time.Minute, "How often a --serve daemon re-reads the template file on its own, in addition to reacting to file changes")
+	concurrency := flag.Int("concurrency", 10, "Maximum number of Sucuri requests to submit at the same time")
+	rps := flag.Float64("rps", 0, "Maximum number of Sucuri requests to submit per second, 0 disables rate limiting")
+	dryRun := flag.Bool("dryRun", false, "Print the plan of changes against Sucuri's current configuration instead of applying them")
+	autoApprove := flag.Bool("autoApprove", false, "Apply the changes after printing the plan, even when --dryRun is set")
+	output := flag.String("output", "text", "Output format for --dryRun: text or json")
 	flag.Parse()
+	if dryRunSubcommand {
+		*dryRun = true
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "expandSubnets" && !*expandSubnets {
+			log.Fatalln("--expandSubnets=false is no longer supported: Sucuri's API has no CIDR submission endpoint, so subnets are always expanded into individual addresses")
+		}
+	})
 
 	// Print settings options and possible values
 	if *showSettingOptions {
@@ -221,45 +364,95 @@ func main() {
 	} else if len(*apiSecret) > 0 && len(*site) > 0 {
 		log.Fatalln("Only use --secret or --site, not both")
 	}
+	if len(*group) > 0 && len(*templatePath) == 0 {
+		log.Fatalln("--group can only be used together with --template")
+	}
+
+	// --serve turns this into a long running daemon: it never falls through
+	// to the one-shot flow below.
+	if len(*serveAddr) > 0 {
+		if len(*templatePath) == 0 {
+			log.Fatalln("--serve requires --template")
+		}
+		if !configFile.Daemon.configured() {
+			log.Fatalln(`--serve requires daemon credentials in config.json (set "daemon": {"bearerToken": "..."} or {"basicAuthUser": "...", "basicAuthPass": "..."})`)
+		}
+		d := newDaemon(&sucuri, *templatePath, configFile.Daemon)
+		log.Fatalln(d.serve(*serveAddr, *reloadInterval))
+	}
+
 	// Parse data to local variables
 	var (
-		requests []SucuriAPI.SucuriRequest
-		wg       sync.WaitGroup
-		wIPs     []string
-		bIPs     []string
+		requests []LabeledRequest
+		aIPs     []string
+		dIPs     []string
+		aSubnets []string
+		dSubnets []string
 	)
-	wPaths := make(map[string]string)
-	bPaths := make(map[string]string)
+	aPaths := make(map[string]string)
+	dPaths := make(map[string]string)
 	settings := make(map[string]string)
 
-	// Check if whitelist IP flag was used and store input in a local variable
+	// Check if allowlist IP flag was used and store input in a local variable
+	if len(*allowlistIP) > 0 {
+		aIPs = strings.Split(*allowlistIP, ",")
+	}
+	// Check if legacy whitelist IP flag was used and store input in a local variable
 	if len(*whitelistIP) > 0 {
-		wIPs = strings.Split(*whitelistIP, ",")
+		log.Println("warning: --whitelistIP is deprecated, use --allowlistIP instead")
+		aIPs = append(aIPs, strings.Split(*whitelistIP, ",")...)
+	}
+	// Check if denylist IP flag was used and store input in a local variable
+	if len(*denylistIP) > 0 {
+		dIPs = strings.Split(*denylistIP, ",")
 	}
-	// Check if blacklist IP flag was used and store input in a local variable
+	// Check if legacy blacklist IP flag was used and store input in a local variable
 	if len(*blacklistIP) > 0 {
-		bIPs = strings.Split(*blacklistIP, ",")
+		log.Println("warning: --blacklistIP is deprecated, use --denylistIP instead")
+		dIPs = append(dIPs, strings.Split(*blacklistIP, ",")...)
 	}
-	// Check if whitelist Subnet flag was used and store input in a local variable
+	// Check if allowlist Subnet flag was used and store input in a local variable
+	if len(*allowlistSubnet) > 0 {
+		aSubnets = append(aSubnets, strings.Split(*allowlistSubnet, ",")...)
+	}
+	// Check if legacy whitelist Subnet flag was used and store input in a local variable
 	if len(*whitelistSubnet) > 0 {
-		ips := getUsableIPs(*whitelistSubnet)
-		wIPs = append(wIPs, ips...)
+		log.Println("warning: --whitelistSubnet is deprecated, use --allowlistSubnet instead")
+		aSubnets = append(aSubnets, strings.Split(*whitelistSubnet, ",")...)
+	}
+	// Check if denylist Subnet flag was used and store input in a local variable
+	if len(*denylistSubnet) > 0 {
+		dSubnets = append(dSubnets, strings.Split(*denylistSubnet, ",")...)
 	}
-	// Check if whitelist Subnet flag was used and store input in a local variable
+	// Check if legacy blacklist Subnet flag was used and store input in a local variable
 	if len(*blacklistSubnet) > 0 {
-		ips := getUsableIPs(*blacklistSubnet)
-		bIPs = append(wIPs, ips...)
+		log.Println("warning: --blacklistSubnet is deprecated, use --denylistSubnet instead")
+		dSubnets = append(dSubnets, strings.Split(*blacklistSubnet, ",")...)
+	}
+	// Check if allowlist Path flag and pattern was used and store inputs in a local variables
+	if len(*allowlistPath) > 0 && len(*pathPattern) > 0 {
+		aPaths[*allowlistPath] = *pathPattern
+	} else if len(*allowlistPath) > 0 || len(*pathPattern) > 0 {
+		fmt.Println("Use both --allowlistPath and --pathPattern")
 	}
-	// Check if whitelist Path flag and pattern was used and store inputs in a local variables
+	// Check if legacy whitelistPath flag and pattern was used and store inputs in a local variables
 	if len(*whitelistPath) > 0 && len(*pathPattern) > 0 {
-		wPaths[*whitelistPath] = *pathPattern
-	} else if len(*whitelistPath) > 0 || len(*pathPattern) > 0 {
+		log.Println("warning: --whitelistPath is deprecated, use --allowlistPath instead")
+		aPaths[*whitelistPath] = *pathPattern
+	} else if len(*whitelistPath) > 0 {
 		fmt.Println("Use both --whitelistPath and --pathPattern")
 	}
-	// Check if blacklistPath flag and pattern was used and store inputs in a local variables
+	// Check if denylistPath flag and pattern was used and store inputs in a local variables
+	if len(*denylistPath) > 0 && len(*pathPattern) > 0 {
+		dPaths[*denylistPath] = *pathPattern
+	} else if len(*denylistPath) > 0 || len(*pathPattern) > 0 {
+		fmt.Println("Use both --denylistPath and --pathPattern")
+	}
+	// Check if legacy blacklistPath flag and pattern was used and store inputs in a local variables
 	if len(*blacklistPath) > 0 && len(*pathPattern) > 0 {
-		bPaths[*blacklistPath] = *pathPattern
-	} else if len(*blacklistPath) > 0 || len(*pathPattern) > 0 {
+		log.Println("warning: --blacklistPath is deprecated, use --denylistPath instead")
+		dPaths[*blacklistPath] = *pathPattern
+	} else if len(*blacklistPath) > 0 {
 		fmt.Println("Use both --blacklistPath and --pathPattern")
 	}
 	// Check if setting and settingVal was used
@@ -271,7 +464,7 @@ func main() {
 
 	// Check if template flag was used. Obtain data from template and parse it to local variables
 	if len(*templatePath) > 0 {
-		template := Template{Settings: make(map[string]string), WhitelistPath: make(map[string]string)}
+		template := Template{Settings: make(map[string]string), AllowlistPath: make(map[string]string), DenylistPath: make(map[string]string)}
 
 		// Open and read all data from template file
 		file, err := ioutil.ReadFile(*templatePath)
@@ -283,68 +476,159 @@ func main() {
 		if err != nil {
 			log.Fatalln("Unable to parse template file, please check the content and refer to the documentation.")
 		}
+		template.resolveLegacyAliases()
 
-		// Create sucuriRequests for all IPs to be whitelisted
-		if len(template.WhitelistIP) > 0 {
-			wIPs = template.WhitelistIP
+		active := template
+		// If a rule group was selected, apply that group's rules instead of
+		// the template's top level ones.
+		if len(*group) > 0 {
+			selected, ok := template.Groups[*group]
+			if !ok {
+				log.Fatalf("Group '%s' was not found in template", *group)
+			}
+			selected.resolveLegacyAliases()
+			active = selected
 		}
-		// Create sucuriRequests for all url paths to be whitelisted
-		if len(template.WhitelistPath) > 0 {
-			wPaths = template.WhitelistPath
+
+		// Create sucuriRequests for all IPs to be allowlisted
+		if len(active.AllowlistIP) > 0 {
+			aIPs = active.AllowlistIP
 		}
-		// Create sucuriRequests for all url paths to be blacklisted
-		if len(template.BlacklistPath) > 0 {
-			bPaths = template.BlacklistPath
+		// Create sucuriRequests for all url paths to be allowlisted
+		if len(active.AllowlistPath) > 0 {
+			aPaths = active.AllowlistPath
+		}
+		// Create sucuriRequests for all url paths to be denylisted
+		if len(active.DenylistPath) > 0 {
+			dPaths = active.DenylistPath
 		}
 		// Check if subnet was listed in the template file and store input in a local variable
-		if len(template.WhitelistSubnet) > 0 {
-			for _, subnet := range template.WhitelistSubnet {
-				ips := getUsableIPs(subnet)
-				wIPs = append(wIPs, ips...)
-			}
+		if len(active.AllowlistSubnet) > 0 {
+			aSubnets = append(aSubnets, active.AllowlistSubnet...)
 		}
 		// Check if subnet was listed in the template file and store input in a local variable
-		if len(template.BlacklistSubnet) > 0 {
-			for _, subnet := range template.BlacklistSubnet {
-				ips := getUsableIPs(subnet)
-				bIPs = append(bIPs, ips...)
-			}
+		if len(active.DenylistSubnet) > 0 {
+			dSubnets = append(dSubnets, active.DenylistSubnet...)
 		}
 		// Create sucuriRequests for each setting change
-		if len(template.Settings) > 0 {
-			for key, value := range template.Settings {
+		if len(active.Settings) > 0 {
+			for key, value := range active.Settings {
 				settings[key] = value
 			}
 		}
+		// Fetch remote sources and merge their entries into the same lists
+		if len(active.Sources) > 0 {
+			if err := applySources(active.Sources, sourceCacheDir(), &aIPs, &dIPs, &aSubnets, &dSubnets, aPaths, dPaths); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+
+	// --dryRun prints a Terraform-style plan against Sucuri's current
+	// configuration instead of applying changes, unless --autoApprove is set.
+	if *dryRun {
+		desired := desiredState{AllowIPs: aIPs, DenyIPs: dIPs, AllowPaths: aPaths, DenyPaths: dPaths, Settings: settings}
+		// Mirror the apply path below: subnets are always expanded into
+		// individual IPs, since Sucuri's API has no CIDR submission endpoint.
+		for _, subnet := range aSubnets {
+			ips, err := getUsableIPs(subnet, *maxExpand)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			desired.AllowIPs = append(desired.AllowIPs, ips...)
+		}
+		for _, subnet := range dSubnets {
+			ips, err := getUsableIPs(subnet, *maxExpand)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			desired.DenyIPs = append(desired.DenyIPs, ips...)
+		}
+
+		changes, err := buildPlan(sourceCacheDir(), desired)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if *output == "json" {
+			if err := PrintPlanJSON(changes); err != nil {
+				log.Fatalln(err)
+			}
+		} else {
+			PrintPlan(changes)
+		}
+		if !*autoApprove {
+			return
+		}
 	}
 
 	// Generate requests from local variables
-	if len(wIPs) > 0 {
-		requests = append(requests, whitelistIPs(wIPs, *delete, &sucuri)...)
+	if len(aIPs) > 0 {
+		requests = append(requests, allowlistIPs(aIPs, *delete, &sucuri)...)
+	}
+	if len(dIPs) > 0 {
+		requests = append(requests, denylistIPs(dIPs, *delete, &sucuri)...)
+	}
+	if len(aSubnets) > 0 {
+		subnetRequests, err := allowlistSubnets(aSubnets, *delete, *maxExpand, &sucuri)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		requests = append(requests, subnetRequests...)
 	}
-	if len(bIPs) > 0 {
-		requests = append(requests, blacklistIPs(bIPs, *delete, &sucuri)...)
+	if len(dSubnets) > 0 {
+		subnetRequests, err := denylistSubnets(dSubnets, *delete, *maxExpand, &sucuri)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		requests = append(requests, subnetRequests...)
 	}
-	if len(wPaths) > 0 {
-		for path, pattern := range wPaths {
-			requests = append(requests, sucuri.WhitelistPath(path, pattern))
+	if len(aPaths) > 0 {
+		for path, pattern := range aPaths {
+			requests = append(requests, allowlistPathRequest(&sucuri, path, pattern))
 		}
 	}
-	if len(bPaths) > 0 {
-		for path, pattern := range bPaths {
-			requests = append(requests, sucuri.BlacklistPath(path, pattern))
+	if len(dPaths) > 0 {
+		for path, pattern := range dPaths {
+			requests = append(requests, denylistPathRequest(&sucuri, path, pattern))
 		}
 	}
 	if len(settings) > 0 {
 		for key, value := range settings {
-			requests = append(requests, sucuri.UpdateSetting(key, value))
+			requests = append(requests, updateSettingRequest(&sucuri, key, value))
 		}
 	}
 
 	// Process all Sucuri Requests
-	for _, request := range requests {
-		wg.Add(1)
-		go submitRequest(request, &wg)
+	executor := NewExecutor(*concurrency, *rps)
+	results := executor.Run(context.Background(), requests)
+	if !PrintSummary(results) {
+		os.Exit(1)
+	}
+
+	// Remember what was just applied, so the next --dryRun/plan has something
+	// to diff against - see buildPlan's doc comment for why this can't be a
+	// live read of Sucuri's configuration instead. Only a --template run
+	// describes a full desired state worth caching; a one-off --allowlistIP
+	// etc. invocation only ever touches a single entry and would otherwise
+	// clobber the cache with an incomplete state.
+	if len(*templatePath) > 0 {
+		applied := desiredState{AllowIPs: aIPs, DenyIPs: dIPs, AllowPaths: aPaths, DenyPaths: dPaths, Settings: settings}
+		for _, subnet := range aSubnets {
+			ips, err := getUsableIPs(subnet, *maxExpand)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			applied.AllowIPs = append(applied.AllowIPs, ips...)
+		}
+		for _, subnet := range dSubnets {
+			ips, err := getUsableIPs(subnet, *maxExpand)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			applied.DenyIPs = append(applied.DenyIPs, ips...)
+		}
+		if err := saveAppliedState(sourceCacheDir(), applied); err != nil {
+			log.Fatalln(err)
+		}
 	}
-	wg.Wait()
 }
@@ -0,0 +1,164 @@
+// Copyright 2021 The SucuriAPI AUTHORS. All rights reserved.
+//
+// Use of this source code is governed by an MIT License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetUsableIPs(t *testing.T) {
+	tests := []struct {
+		name      string
+		subnet    string
+		maxExpand int
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "ipv4 /30 drops network and broadcast",
+			subnet:    "192.0.2.0/30",
+			maxExpand: 64,
+			want:      []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:      "ipv4 /31 keeps both addresses",
+			subnet:    "192.0.2.0/31",
+			maxExpand: 64,
+			want:      []string{"192.0.2.0", "192.0.2.1"},
+		},
+		{
+			name:      "ipv4 /32 keeps the single address",
+			subnet:    "192.0.2.1/32",
+			maxExpand: 64,
+			want:      []string{"192.0.2.1"},
+		},
+		{
+			name:      "ipv6 /126 has no broadcast address to drop",
+			subnet:    "2001:db8::/126",
+			maxExpand: 64,
+			want:      []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"},
+		},
+		{
+			name:      "ipv6 /128 keeps the single address",
+			subnet:    "2001:db8::1/128",
+			maxExpand: 64,
+			want:      []string{"2001:db8::1"},
+		},
+		{
+			name:      "over maxExpand is rejected",
+			subnet:    "192.0.2.0/24",
+			maxExpand: 10,
+			wantErr:   true,
+		},
+		{
+			name:      "too large to expand is rejected before the maxExpand check",
+			subnet:    "2001:db8::/32",
+			maxExpand: 1 << 30,
+			wantErr:   true,
+		},
+		{
+			name:      "zero maxExpand is rejected",
+			subnet:    "192.0.2.0/30",
+			maxExpand: 0,
+			wantErr:   true,
+		},
+		{
+			name:      "negative maxExpand is rejected rather than wrapping around",
+			subnet:    "192.0.2.0/24",
+			maxExpand: -1,
+			wantErr:   true,
+		},
+		{
+			name:      "invalid subnet is rejected",
+			subnet:    "not-a-subnet",
+			maxExpand: 64,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getUsableIPs(tt.subnet, tt.maxExpand)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("getUsableIPs(%q, %d) = %v, want error", tt.subnet, tt.maxExpand, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getUsableIPs(%q, %d) returned unexpected error: %s", tt.subnet, tt.maxExpand, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("getUsableIPs(%q, %d) = %v, want %v", tt.subnet, tt.maxExpand, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("getUsableIPs(%q, %d) = %v, want %v", tt.subnet, tt.maxExpand, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveLegacyAliases(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Template
+		want Template
+	}{
+		{
+			name: "legacy IP fields are folded into the replacements",
+			in:   Template{AllowlistIP: []string{"1.1.1.1"}, WhitelistIP: []string{"2.2.2.2"}, DenylistIP: []string{"3.3.3.3"}, BlacklistIP: []string{"4.4.4.4"}},
+			want: Template{AllowlistIP: []string{"1.1.1.1", "2.2.2.2"}, WhitelistIP: []string{"2.2.2.2"}, DenylistIP: []string{"3.3.3.3", "4.4.4.4"}, BlacklistIP: []string{"4.4.4.4"}},
+		},
+		{
+			name: "legacy subnet fields are folded into the replacements",
+			in:   Template{AllowlistSubnet: []string{"10.0.0.0/8"}, WhitelistSubnet: []string{"10.1.0.0/16"}, DenylistSubnet: []string{"10.2.0.0/16"}, BlacklistSubnet: []string{"10.3.0.0/16"}},
+			want: Template{AllowlistSubnet: []string{"10.0.0.0/8", "10.1.0.0/16"}, WhitelistSubnet: []string{"10.1.0.0/16"}, DenylistSubnet: []string{"10.2.0.0/16", "10.3.0.0/16"}, BlacklistSubnet: []string{"10.3.0.0/16"}},
+		},
+		{
+			name: "legacy path fields are merged into the replacements",
+			in:   Template{AllowlistPath: map[string]string{"/a": "equals"}, WhitelistPath: map[string]string{"/b": "begins_with"}, DenylistPath: map[string]string{"/c": "equals"}, BlacklistPath: map[string]string{"/d": "begins_with"}},
+			want: Template{AllowlistPath: map[string]string{"/a": "equals", "/b": "begins_with"}, WhitelistPath: map[string]string{"/b": "begins_with"}, DenylistPath: map[string]string{"/c": "equals", "/d": "begins_with"}, BlacklistPath: map[string]string{"/d": "begins_with"}},
+		},
+		{
+			name: "no legacy fields leaves the template untouched",
+			in:   Template{AllowlistIP: []string{"1.1.1.1"}},
+			want: Template{AllowlistIP: []string{"1.1.1.1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.in.resolveLegacyAliases()
+			if !reflect.DeepEqual(tt.in, tt.want) {
+				t.Fatalf("resolveLegacyAliases() = %+v, want %+v", tt.in, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupSelection(t *testing.T) {
+	template := Template{
+		AllowlistIP: []string{"1.1.1.1"},
+		Groups: map[string]Template{
+			"office": {AllowlistIP: []string{"2.2.2.2"}},
+		},
+	}
+
+	selected, ok := template.Groups["office"]
+	if !ok {
+		t.Fatal(`Groups["office"] not found`)
+	}
+	if !reflect.DeepEqual(selected.AllowlistIP, []string{"2.2.2.2"}) {
+		t.Fatalf("selected group AllowlistIP = %v, want [2.2.2.2]", selected.AllowlistIP)
+	}
+
+	if _, ok := template.Groups["missing"]; ok {
+		t.Fatal(`Groups["missing"] unexpectedly found`)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 The SucuriAPI AUTHORS. All rights reserved.
+//
+// Use of this source code is governed by an MIT License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// PlanChange is a single addition, removal or update a plan would apply to
+// converge Sucuri on the desired state.
+type PlanChange struct {
+	Op      string `json:"op"` // "add", "remove" or "update"
+	Kind    string `json:"kind"`
+	Value   string `json:"value"`
+	Pattern string `json:"pattern,omitempty"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+}
+
+// desiredState is the allow/deny state a plan diffs against, built from the
+// same flags/template/sources pipeline as a normal apply. Subnets are always
+// pre-expanded into AllowIPs/DenyIPs before a desiredState is built, since
+// Sucuri's API has no CIDR submission endpoint to diff against.
+type desiredState struct {
+	AllowIPs   []string          `json:"allowIPs,omitempty"`
+	DenyIPs    []string          `json:"denyIPs,omitempty"`
+	AllowPaths map[string]string `json:"allowPaths,omitempty"`
+	DenyPaths  map[string]string `json:"denyPaths,omitempty"`
+	Settings   map[string]string `json:"settings,omitempty"`
+}
+
+// buildPlan diffs desired against the state cached under cacheDir from the
+// last --template apply, returning the changes needed to converge.
+//
+// SucuriAPI-Go exposes no way to read Sucuri's current configuration back -
+// only one-way submission methods like WhitelistIP/UpdateSetting - so a plan
+// can't be built against Sucuri's live state the way "terraform plan" diffs
+// against a live read of real infrastructure. Instead it diffs against
+// appliedStateFile, the state this tool itself last successfully applied;
+// changes made to Sucuri outside this tool (the web UI, another copy of this
+// tool, ...) won't show up until the next apply overwrites them.
+func buildPlan(cacheDir string, desired desiredState) ([]PlanChange, error) {
+	current, err := loadAppliedState(cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("loading last applied state: %w", err)
+	}
+
+	var changes []PlanChange
+	changes = append(changes, diffIPSet("allowlist", current.AllowIPs, desired.AllowIPs)...)
+	changes = append(changes, diffIPSet("denylist", current.DenyIPs, desired.DenyIPs)...)
+	changes = append(changes, diffPathSet("allowlist path", current.AllowPaths, desired.AllowPaths)...)
+	changes = append(changes, diffPathSet("denylist path", current.DenyPaths, desired.DenyPaths)...)
+	changes = append(changes, diffSettings(current.Settings, desired.Settings)...)
+	return changes, nil
+}
+
+// diffIPSet compares a current and desired IP list, in either direction.
+func diffIPSet(kind string, current []string, desired []string) []PlanChange {
+	currentSet := make(map[string]bool, len(current))
+	for _, ip := range current {
+		currentSet[ip] = true
+	}
+	desiredSet := make(map[string]bool, len(desired))
+
+	var changes []PlanChange
+	for _, ip := range desired {
+		desiredSet[ip] = true
+		if !currentSet[ip] {
+			changes = append(changes, PlanChange{Op: "add", Kind: kind, Value: ip})
+		}
+	}
+	for _, ip := range current {
+		if !desiredSet[ip] {
+			changes = append(changes, PlanChange{Op: "remove", Kind: kind, Value: ip})
+		}
+	}
+	return changes
+}
+
+// diffPathSet is the path-map counterpart of diffIPSet; a path present on
+// both sides with a different pattern is reported as an update.
+func diffPathSet(kind string, current map[string]string, desired map[string]string) []PlanChange {
+	var changes []PlanChange
+	for path, pattern := range desired {
+		currentPattern, ok := current[path]
+		switch {
+		case !ok:
+			changes = append(changes, PlanChange{Op: "add", Kind: kind, Value: path, Pattern: pattern})
+		case currentPattern != pattern:
+			changes = append(changes, PlanChange{Op: "update", Kind: kind, Value: path, From: currentPattern, To: pattern})
+		}
+	}
+	for path, pattern := range current {
+		if _, ok := desired[path]; !ok {
+			changes = append(changes, PlanChange{Op: "remove", Kind: kind, Value: path, Pattern: pattern})
+		}
+	}
+	return changes
+}
+
+// diffSettings reports a setting as an update whenever it's missing or
+// different on the current side.
+func diffSettings(current map[string]string, desired map[string]string) []PlanChange {
+	var changes []PlanChange
+	for key, value := range desired {
+		currentValue := current[key]
+		if currentValue != value {
+			changes = append(changes, PlanChange{Op: "update", Kind: "setting", Value: key, From: currentValue, To: value})
+		}
+	}
+	return changes
+}
+
+// appliedStateFile returns the path of the cache file that holds the state
+// this tool last successfully applied, under the same cache directory remote
+// source bodies are cached in (see sources.go's sourceCacheDir).
+func appliedStateFile(cacheDir string) string {
+	return filepath.Join(cacheDir, "applied-state.json")
+}
+
+// loadAppliedState reads the cached applied state, returning a zero-value
+// desiredState (so every desired entry shows up as an "add") if nothing has
+// been applied yet.
+func loadAppliedState(cacheDir string) (desiredState, error) {
+	data, err := ioutil.ReadFile(appliedStateFile(cacheDir))
+	if os.IsNotExist(err) {
+		return desiredState{}, nil
+	}
+	if err != nil {
+		return desiredState{}, err
+	}
+	var state desiredState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return desiredState{}, err
+	}
+	return state, nil
+}
+
+// saveAppliedState overwrites the cached applied state with desired, so the
+// next plan diffs against what was just applied.
+func saveAppliedState(cacheDir string, desired desiredState) error {
+	data, err := json.MarshalIndent(desired, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appliedStateFile(cacheDir), data, 0o644)
+}
+
+// PrintPlan renders changes as a human-readable, Terraform-style plan.
+func PrintPlan(changes []PlanChange) {
+	if len(changes) == 0 {
+		fmt.Println("No changes. Sucuri already matches the last applied state.")
+		return
+	}
+	for _, change := range changes {
+		switch change.Op {
+		case "add":
+			if len(change.Pattern) > 0 {
+				fmt.Printf("+ %s %s (%s)\n", change.Kind, change.Value, change.Pattern)
+			} else {
+				fmt.Printf("+ %s %s\n", change.Kind, change.Value)
+			}
+		case "remove":
+			if len(change.Pattern) > 0 {
+				fmt.Printf("- %s %s (%s)\n", change.Kind, change.Value, change.Pattern)
+			} else {
+				fmt.Printf("- %s %s\n", change.Kind, change.Value)
+			}
+		case "update":
+			fmt.Printf("~ %s %s: %s -> %s\n", change.Kind, change.Value, change.From, change.To)
+		}
+	}
+}
+
+// PrintPlanJSON renders changes as JSON, for --output json.
+func PrintPlanJSON(changes []PlanChange) error {
+	if changes == nil {
+		changes = []PlanChange{}
+	}
+	encoded, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(encoded))
+	return nil
+}
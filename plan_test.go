@@ -0,0 +1,143 @@
+// Copyright 2021 The SucuriAPI AUTHORS. All rights reserved.
+//
+// Use of this source code is governed by an MIT License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func sortedChanges(changes []PlanChange) []PlanChange {
+	sorted := append([]PlanChange{}, changes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Op != sorted[j].Op {
+			return sorted[i].Op < sorted[j].Op
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	return sorted
+}
+
+func TestDiffIPSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		current []string
+		desired []string
+		want    []PlanChange
+	}{
+		{
+			name:    "adds missing entries",
+			current: nil,
+			desired: []string{"1.1.1.1"},
+			want:    []PlanChange{{Op: "add", Kind: "allowlist", Value: "1.1.1.1"}},
+		},
+		{
+			name:    "removes stray entries",
+			current: []string{"1.1.1.1"},
+			desired: nil,
+			want:    []PlanChange{{Op: "remove", Kind: "allowlist", Value: "1.1.1.1"}},
+		},
+		{
+			name:    "matching entries produce no changes",
+			current: []string{"1.1.1.1"},
+			desired: []string{"1.1.1.1"},
+			want:    nil,
+		},
+		{
+			name:    "add and remove together",
+			current: []string{"1.1.1.1"},
+			desired: []string{"2.2.2.2"},
+			want: []PlanChange{
+				{Op: "add", Kind: "allowlist", Value: "2.2.2.2"},
+				{Op: "remove", Kind: "allowlist", Value: "1.1.1.1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sortedChanges(diffIPSet("allowlist", tt.current, tt.desired))
+			want := sortedChanges(tt.want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("diffIPSet(%v, %v) = %v, want %v", tt.current, tt.desired, got, want)
+			}
+		})
+	}
+}
+
+func TestDiffPathSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		current map[string]string
+		desired map[string]string
+		want    []PlanChange
+	}{
+		{
+			name:    "add a new path",
+			current: map[string]string{},
+			desired: map[string]string{"/admin": "begins_with"},
+			want:    []PlanChange{{Op: "add", Kind: "allowlist path", Value: "/admin", Pattern: "begins_with"}},
+		},
+		{
+			name:    "remove a stray path",
+			current: map[string]string{"/admin": "begins_with"},
+			desired: map[string]string{},
+			want:    []PlanChange{{Op: "remove", Kind: "allowlist path", Value: "/admin", Pattern: "begins_with"}},
+		},
+		{
+			name:    "update a changed pattern",
+			current: map[string]string{"/admin": "begins_with"},
+			desired: map[string]string{"/admin": "equals"},
+			want:    []PlanChange{{Op: "update", Kind: "allowlist path", Value: "/admin", From: "begins_with", To: "equals"}},
+		},
+		{
+			name:    "matching path produces no change",
+			current: map[string]string{"/admin": "begins_with"},
+			desired: map[string]string{"/admin": "begins_with"},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sortedChanges(diffPathSet("allowlist path", tt.current, tt.desired))
+			want := sortedChanges(tt.want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("diffPathSet(%v, %v) = %v, want %v", tt.current, tt.desired, got, want)
+			}
+		})
+	}
+}
+
+func TestLoadAppliedStateMissing(t *testing.T) {
+	got, err := loadAppliedState(t.TempDir())
+	if err != nil {
+		t.Fatalf("loadAppliedState on a missing cache = %s, want nil error", err)
+	}
+	if !reflect.DeepEqual(got, desiredState{}) {
+		t.Fatalf("loadAppliedState on a missing cache = %+v, want zero value", got)
+	}
+}
+
+func TestSaveAndLoadAppliedState(t *testing.T) {
+	dir := t.TempDir()
+	want := desiredState{
+		AllowIPs:   []string{"1.1.1.1"},
+		AllowPaths: map[string]string{"/admin": "begins_with"},
+		Settings:   map[string]string{"securitylevel": "high"},
+	}
+	if err := saveAppliedState(dir, want); err != nil {
+		t.Fatalf("saveAppliedState returned unexpected error: %s", err)
+	}
+	got, err := loadAppliedState(dir)
+	if err != nil {
+		t.Fatalf("loadAppliedState returned unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("loadAppliedState = %+v, want %+v", got, want)
+	}
+}
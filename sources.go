@@ -0,0 +1,238 @@
+// Copyright 2021 The SucuriAPI AUTHORS. All rights reserved.
+//
+// Use of this source code is governed by an MIT License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Source describes a remote allow/deny list to pull into a template. Type
+// selects which pipeline the fetched entries feed into and must be one of
+// "allowlistIP", "denylistIP", "allowlistSubnet", "denylistSubnet",
+// "allowlistPath" or "denylistPath".
+type Source struct {
+	URL  string `json:"url"`
+	Type string `json:"type"`
+}
+
+// pathSourceEntry is the shape accepted for JSON-array path sources.
+type pathSourceEntry struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+}
+
+// sourceCacheEntry is what gets written under the cache directory for a
+// fetched source, so an unchanged remote list doesn't get re-downloaded on
+// every daemon reload.
+type sourceCacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Body         string `json:"body"`
+}
+
+// applySources fetches every configured source and merges its entries into
+// the matching list/map, skipping anything already present so a source
+// doesn't produce duplicate requests for an entry also given via flags or the
+// template itself.
+func applySources(sources []Source, cacheDir string, aIPs, dIPs, aSubnets, dSubnets *[]string, aPaths, dPaths map[string]string) error {
+	if len(sources) == 0 {
+		return nil
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, source := range sources {
+		body, err := fetchSourceBody(client, source.URL, cacheDir)
+		if err != nil {
+			return fmt.Errorf("source %s: %w", source.URL, err)
+		}
+		switch source.Type {
+		case "allowlistIP":
+			*aIPs = appendMissing(*aIPs, parseListSource(body))
+		case "denylistIP":
+			*dIPs = appendMissing(*dIPs, parseListSource(body))
+		case "allowlistSubnet":
+			*aSubnets = appendMissing(*aSubnets, parseListSource(body))
+		case "denylistSubnet":
+			*dSubnets = appendMissing(*dSubnets, parseListSource(body))
+		case "allowlistPath":
+			for _, entry := range parsePathSource(body) {
+				if _, ok := aPaths[entry.Path]; !ok {
+					aPaths[entry.Path] = entry.Pattern
+				}
+			}
+		case "denylistPath":
+			for _, entry := range parsePathSource(body) {
+				if _, ok := dPaths[entry.Path]; !ok {
+					dPaths[entry.Path] = entry.Pattern
+				}
+			}
+		default:
+			return fmt.Errorf("source %s: unknown type %q", source.URL, source.Type)
+		}
+	}
+	return nil
+}
+
+// appendMissing appends the entries of additions that aren't already present
+// in existing, preserving existing's order.
+func appendMissing(existing []string, additions []string) []string {
+	seen := make(map[string]bool, len(existing))
+	for _, entry := range existing {
+		seen[entry] = true
+	}
+	for _, entry := range additions {
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		existing = append(existing, entry)
+	}
+	return existing
+}
+
+// parseListSource autodetects a fetched IP/CIDR list's format: a JSON array,
+// or line based text where '#' starts a comment and, hosts-file style, the
+// last whitespace-separated field on the line is taken as the entry.
+func parseListSource(body []byte) []string {
+	if entries, ok := parseJSONArray(body); ok {
+		return entries
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		fields := strings.Fields(line)
+		entries = append(entries, fields[len(fields)-1])
+	}
+	return entries
+}
+
+// parsePathSource autodetects a fetched path list's format: a JSON array of
+// {"path", "pattern"} objects, or line based text of "<path> <pattern>",
+// defaulting to the "equals" pattern when a line only has a path.
+func parsePathSource(body []byte) []pathSourceEntry {
+	trimmed := strings.TrimSpace(string(body))
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var entries []pathSourceEntry
+		if err := json.Unmarshal([]byte(trimmed), &entries); err == nil {
+			return entries
+		}
+	}
+
+	var entries []pathSourceEntry
+	for _, line := range strings.Split(string(body), "\n") {
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			entries = append(entries, pathSourceEntry{Path: fields[0], Pattern: fields[1]})
+		} else {
+			entries = append(entries, pathSourceEntry{Path: fields[0], Pattern: "equals"})
+		}
+	}
+	return entries
+}
+
+// parseJSONArray returns the string entries of body if it's a JSON array,
+// and false if it isn't JSON at all.
+func parseJSONArray(body []byte) ([]string, bool) {
+	trimmed := strings.TrimSpace(string(body))
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return nil, false
+	}
+	var entries []string
+	if err := json.Unmarshal([]byte(trimmed), &entries); err != nil {
+		return nil, false
+	}
+	return entries, true
+}
+
+// fetchSourceBody fetches url, sending the cached ETag/Last-Modified for
+// conditional requests, and returns the cached body unchanged on a 304.
+func fetchSourceBody(client *http.Client, url string, cacheDir string) ([]byte, error) {
+	path := sourceCachePath(cacheDir, url)
+	var cached sourceCacheEntry
+	if data, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(data, &cached)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(cached.ETag) > 0 {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if len(cached.LastModified) > 0 {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && len(cached.Body) > 0 {
+		return []byte(cached.Body), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := sourceCacheEntry{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Body:         string(body),
+	}
+	if data, err := json.Marshal(entry); err == nil {
+		if err := os.MkdirAll(cacheDir, 0o755); err == nil {
+			ioutil.WriteFile(path, data, 0o644)
+		}
+	}
+	return body, nil
+}
+
+// sourceCacheDir returns the directory remote source caches are written
+// under: a ".sucuri-sources-cache" directory inside the current working
+// directory.
+func sourceCacheDir() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ".sucuri-sources-cache"
+	}
+	return filepath.Join(dir, ".sucuri-sources-cache")
+}
+
+// sourceCachePath maps a source URL to its cache file under cacheDir.
+func sourceCachePath(cacheDir string, url string) string {
+	hash := sha256.Sum256([]byte(url))
+	return filepath.Join(cacheDir, hex.EncodeToString(hash[:])+".json")
+}
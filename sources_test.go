@@ -0,0 +1,96 @@
+// Copyright 2021 The SucuriAPI AUTHORS. All rights reserved.
+//
+// Use of this source code is governed by an MIT License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseListSource(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "json array",
+			body: `["1.2.3.4", "5.6.7.0/24"]`,
+			want: []string{"1.2.3.4", "5.6.7.0/24"},
+		},
+		{
+			name: "line based",
+			body: "1.2.3.4\n5.6.7.0/24\n",
+			want: []string{"1.2.3.4", "5.6.7.0/24"},
+		},
+		{
+			name: "comments and blank lines are skipped",
+			body: "# comment\n1.2.3.4\n\n   \n5.6.7.0/24 # trailing comment\n",
+			want: []string{"1.2.3.4", "5.6.7.0/24"},
+		},
+		{
+			name: "hosts file style takes the last field",
+			body: "0.0.0.0 badhost.example\n127.0.0.1 localhost\n",
+			want: []string{"badhost.example", "localhost"},
+		},
+		{
+			name: "empty body",
+			body: "",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseListSource([]byte(tt.body))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseListSource(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePathSource(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []pathSourceEntry
+	}{
+		{
+			name: "json array",
+			body: `[{"path": "/admin", "pattern": "begins_with"}]`,
+			want: []pathSourceEntry{{Path: "/admin", Pattern: "begins_with"}},
+		},
+		{
+			name: "line based with pattern",
+			body: "/admin begins_with\n",
+			want: []pathSourceEntry{{Path: "/admin", Pattern: "begins_with"}},
+		},
+		{
+			name: "line based defaults to equals",
+			body: "/login\n",
+			want: []pathSourceEntry{{Path: "/login", Pattern: "equals"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePathSource([]byte(tt.body))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parsePathSource(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendMissing(t *testing.T) {
+	existing := []string{"1.1.1.1", "2.2.2.2"}
+	got := appendMissing(existing, []string{"2.2.2.2", "3.3.3.3"})
+	want := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("appendMissing = %v, want %v", got, want)
+	}
+}
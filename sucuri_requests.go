@@ -0,0 +1,117 @@
+// Copyright 2021 The SucuriAPI AUTHORS. All rights reserved.
+//
+// Use of this source code is governed by an MIT License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/ZsoltFejes/SucuriAPI-Go"
+)
+
+// LabeledRequest is a single call to the Sucuri WAF API, together with a
+// short, credential-free label describing it (e.g. "allowlist ip 1.2.3.4").
+//
+// SucuriAPI-Go's own SucuriRequest.Submit() takes no arguments and returns
+// nothing - it prints its result to stdout and swallows its own errors -
+// which makes it impossible to learn whether a request actually succeeded.
+// LabeledRequest instead builds the same query parameters SucuriAPI-Go's
+// request constructors (WhitelistIP, UpdateSetting, ...) build internally,
+// and submit() performs the HTTP call itself so callers get a real
+// (response, error) back.
+type LabeledRequest struct {
+	Label  string
+	sucuri *SucuriAPI.Sucuri
+	params url.Values
+}
+
+// sucuriResponse is the JSON envelope every Sucuri WAF API call returns.
+type sucuriResponse struct {
+	Status   int      `json:"status"`
+	Messages []string `json:"messages"`
+}
+
+// submit sends the request to the Sucuri WAF API and returns its first
+// status message, or an error if the HTTP call failed or the response
+// couldn't be parsed.
+func (r LabeledRequest) submit(ctx context.Context) (string, error) {
+	params := url.Values{}
+	for key, values := range r.params {
+		params[key] = values
+	}
+	params.Set("k", r.sucuri.ApiKey)
+	params.Set("s", r.sucuri.ApiSecret)
+
+	requestURL, err := url.Parse(r.sucuri.Url + "&" + params.Encode())
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var decoded sucuriResponse
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return "", fmt.Errorf("unable to parse response: %w (body: %s)", err, body)
+	}
+	if len(decoded.Messages) == 0 {
+		return "", nil
+	}
+	return decoded.Messages[0], nil
+}
+
+// allowlistIPRequest builds a LabeledRequest that adds ip to Sucuri's
+// allowlisted IPs, or removes it when remove is true.
+func allowlistIPRequest(sucuri *SucuriAPI.Sucuri, ip string, remove bool) LabeledRequest {
+	action, label := "allowlist_ip", fmt.Sprintf("allowlist ip %s", ip)
+	if remove {
+		action, label = "delete_allowlist_ip", fmt.Sprintf("unallowlist ip %s", ip)
+	}
+	return LabeledRequest{Label: label, sucuri: sucuri, params: url.Values{"a": {action}, "ip": {ip}}}
+}
+
+// denylistIPRequest is the denylist counterpart of allowlistIPRequest.
+func denylistIPRequest(sucuri *SucuriAPI.Sucuri, ip string, remove bool) LabeledRequest {
+	action, label := "blacklist_ip", fmt.Sprintf("denylist ip %s", ip)
+	if remove {
+		action, label = "delete_blacklist_ip", fmt.Sprintf("undenylist ip %s", ip)
+	}
+	return LabeledRequest{Label: label, sucuri: sucuri, params: url.Values{"a": {action}, "ip": {ip}}}
+}
+
+// allowlistPathRequest builds a LabeledRequest that adds path to Sucuri's
+// allowlisted paths, matched using pattern (matches|begins_with|ends_with|equals).
+func allowlistPathRequest(sucuri *SucuriAPI.Sucuri, path string, pattern string) LabeledRequest {
+	params := url.Values{"a": {"update_setting"}, "allowlist_dir": {path}, "allowlist_dir_pattern": {pattern}}
+	return LabeledRequest{Label: fmt.Sprintf("allowlist path %s (%s)", path, pattern), sucuri: sucuri, params: params}
+}
+
+// denylistPathRequest is the denylist counterpart of allowlistPathRequest.
+func denylistPathRequest(sucuri *SucuriAPI.Sucuri, path string, pattern string) LabeledRequest {
+	params := url.Values{"a": {"update_setting"}, "blacklist_dir": {path}, "blacklist_dir_pattern": {pattern}}
+	return LabeledRequest{Label: fmt.Sprintf("denylist path %s (%s)", path, pattern), sucuri: sucuri, params: params}
+}
+
+// updateSettingRequest builds a LabeledRequest that overwrites the specified
+// Sucuri setting.
+func updateSettingRequest(sucuri *SucuriAPI.Sucuri, key string, value string) LabeledRequest {
+	params := url.Values{"a": {"update_setting"}, key: {value}}
+	return LabeledRequest{Label: fmt.Sprintf("setting %s=%s", key, value), sucuri: sucuri, params: params}
+}